@@ -0,0 +1,64 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build python
+
+package python
+
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/DataDog/datadog-agent/pkg/collector/runner"
+)
+
+/*
+#include <datadog_agent_six.h>
+#include <stdlib.h>
+*/
+import "C"
+
+// PythonCheck is one check instance pinned to a specific major Python
+// version. The collector runner schedules these like any other check;
+// Run is what actually threads the check to the correct interpreter, so
+// Python 2 and Python 3 checks can run concurrently while an inventory
+// migrates from one to the other.
+type PythonCheck struct {
+	ID            string
+	PythonVersion int
+	paths         []string
+}
+
+// NewPythonCheck builds a check instance pinned to the given major Python
+// version, loading it from the given additional check paths if the
+// runtime for that version isn't already up.
+func NewPythonCheck(id string, pythonVersion int, paths ...string) *PythonCheck {
+	return &PythonCheck{ID: id, PythonVersion: pythonVersion, paths: paths}
+}
+
+// Run dispatches the check to the interpreter it's pinned to, starting
+// that runtime on demand via Dispatch, and registers the run with the
+// collector runner's quiesce barrier so a concurrent Reload/DestroyVersion
+// can't tear the six handle out from under it mid-run.
+func (c *PythonCheck) Run() error {
+	leave := runner.EnterPythonCheck(c.PythonVersion)
+	defer leave()
+
+	six, err := Dispatch(c.PythonVersion, c.paths...)
+	if err != nil {
+		return err
+	}
+	if six == nil {
+		return fmt.Errorf("python runtime %d not available for check %s", c.PythonVersion, c.ID)
+	}
+
+	id := C.CString(c.ID)
+	defer C.free(unsafe.Pointer(id))
+
+	if C.run_check(six, id) == 0 {
+		return fmt.Errorf("%s", C.GoString(C.get_error(six)))
+	}
+	return nil
+}
@@ -5,28 +5,27 @@
 
 // +build python
 
+// Package python embeds and drives the Agent's Python interpreters. None of
+// the telemetry in this package is systemd-linked, so there's nothing here
+// that needs a build-tag guard to keep non-Linux hosts compiling cleanly;
+// sendTelemetry only ever talks to the aggregator.
 package python
 
 import (
 	"fmt"
-	"os"
-	"path/filepath"
-	"runtime"
 	"strings"
+	"sync"
 
 	"github.com/DataDog/datadog-agent/pkg/aggregator"
+	"github.com/DataDog/datadog-agent/pkg/collector/runner"
 	"github.com/DataDog/datadog-agent/pkg/config"
 	"github.com/DataDog/datadog-agent/pkg/metrics"
 	"github.com/DataDog/datadog-agent/pkg/util/cache"
-	"github.com/DataDog/datadog-agent/pkg/util/executable"
 	"github.com/DataDog/datadog-agent/pkg/version"
 )
 
 /*
 #include <datadog_agent_six.h>
-#cgo !windows LDFLAGS: -ldatadog-agent-six -ldl
-#cgo windows LDFLAGS: -ldatadog-agent-six -lstdc++ -static
-
 #include <stdlib.h>
 
 // helpers
@@ -74,9 +73,9 @@ void initDatadogAgentModule(six_t *six) {
 // aggregator module
 //
 
-void SubmitMetric(char *, metric_type_t, char *, float, char **, int, char *);
-void SubmitServiceCheck(char *, char *, int, char **, int, char *, char *);
-void SubmitEvent(char *, event_t *, int);
+void SubmitMetric(six_t *, char *, metric_type_t, char *, float, char **, int, char *);
+void SubmitServiceCheck(six_t *, char *, char *, int, char **, int, char *, char *);
+void SubmitEvent(six_t *, char *, event_t *, int);
 
 void initAggregatorModule(six_t *six) {
 	set_submit_metric_cb(six, SubmitMetric);
@@ -88,7 +87,7 @@ void initAggregatorModule(six_t *six) {
 // _util module
 //
 
-void GetSubprocessOutput(char **, int, char **, char **, int*, char **);
+void GetSubprocessOutput(six_t *, char **, int, char **, char **, int*, char **);
 
 void initUtilModule(six_t *six) {
 	set_get_subprocess_output_cb(six, GetSubprocessOutput);
@@ -98,7 +97,7 @@ void initUtilModule(six_t *six) {
 // tagger module
 //
 
-char **Tags(char **, int);
+char **Tags(six_t *, char **, int);
 
 void initTaggerModule(six_t *six) {
 	set_tags_cb(six, Tags);
@@ -108,7 +107,7 @@ void initTaggerModule(six_t *six) {
 // containers module
 //
 
-int IsContainerExcluded(char *, char *);
+int IsContainerExcluded(six_t *, char *, char *);
 
 void initContainersModule(six_t *six) {
 	set_is_excluded_cb(six, IsContainerExcluded);
@@ -118,7 +117,7 @@ void initContainersModule(six_t *six) {
 // kubeutil module
 //
 
-void GetKubeletConnectionInfo(char *);
+void GetKubeletConnectionInfo(six_t *, char *);
 
 void initkubeutilModule(six_t *six) {
 	set_get_connection_info_cb(six, GetKubeletConnectionInfo);
@@ -126,27 +125,38 @@ void initkubeutilModule(six_t *six) {
 */
 import "C"
 
+// interpreter holds the state associated with a single embedded Python
+// runtime. The Agent can keep one of these alive per major Python version
+// so that Python 2 and Python 3 checks can be scheduled concurrently while
+// an inventory migrates from one to the other.
+type interpreter struct {
+	six        *C.six_t
+	pythonHome string
+	pythonPath string
+	version    string
+}
+
 var (
-	// PythonVersion contains the interpreter version string provided by
-	// `sys.version`. It's empty if the interpreter was not initialized.
-	PythonVersion = ""
-	// The pythonHome variable typically comes from -ldflags
-	// it's needed in case the agent was built using embedded libs
+	runtimesMu sync.RWMutex
+	// runtimes is keyed by major Python version (2 or 3).
+	runtimes = map[int]*interpreter{}
+
+	// The pythonHome2/pythonHome3 variables typically come from -ldflags,
+	// they're needed in case the agent was built using embedded libs.
 	pythonHome2 = ""
 	pythonHome3 = ""
-	// PythonHome contains the computed value of the Python Home path once the
-	// intepreter is created. It might be empty in case the interpreter wasn't
-	// initialized, or the Agent was built using system libs and the env var
-	// PYTHONHOME is empty. It's expected to always contain a value when the
-	// Agent is built using embedded libs.
-	PythonHome = ""
-	// PythonPath contains the string representation of the Python list returned
-	// by `sys.path`. It's empty if the interpreter was not initialized.
-	PythonPath = ""
-
-	six *C.six_t = nil
 )
 
+// pythonHomeFor returns the compile-time default for the given major Python
+// version, as set via -ldflags. Platform-specific init_*.go files decide
+// whether to trust this value or recompute it.
+func pythonHomeFor(pythonVersion int) string {
+	if pythonVersion == 2 {
+		return pythonHome2
+	}
+	return pythonHome3
+}
+
 func sendTelemetry(pythonVersion int) {
 	tags := []string{
 		fmt.Sprintf("python_version:%d", pythonVersion),
@@ -166,78 +176,299 @@ func sendTelemetry(pythonVersion int) {
 	})
 }
 
+// Initialize brings up the embedded Python interpreter for the major
+// version configured via `python_version`. Additional runtimes can be
+// started on demand via EnsureRuntime, e.g. when a check pins a specific
+// major version while the rest of the inventory has already moved on.
 func Initialize(paths ...string) error {
-	pythonVersion := config.Datadog.GetInt("python_version")
+	return EnsureRuntime(config.Datadog.GetInt("python_version"), paths...)
+}
 
-	if pythonVersion == 2 {
-		six = C.make2(C.CString(pythonHome2))
-		PythonHome = pythonHome2
-	} else if pythonVersion == 3 {
-		six = C.make3(C.CString(pythonHome3))
-		PythonHome = pythonHome3
-	} else {
-		return fmt.Errorf("unknown requested version of python: %d", pythonVersion)
+// Reload tears down the Python interpreter matching the configured
+// `python_version` and brings it back up from scratch.
+//
+// Deprecated: this only ever targets whichever version config currently
+// points at, so it can't reload or retire a runtime left over from before
+// `python_version` was switched. Use ReloadVersion/DestroyVersion to name
+// the runtime explicitly.
+func Reload(paths ...string) error {
+	return ReloadVersion(config.Datadog.GetInt("python_version"), paths...)
+}
+
+// ReloadVersion tears down the interpreter for the given major Python
+// version, if running, and brings it back up from scratch. It picks up any
+// change to `additional_checksd` (e.g. new wheels dropped by
+// `datadog-agent integration install`) and lets operators recover a
+// wedged interpreter without bouncing the Agent process and losing state
+// like the DogStatsD UDP buffers.
+//
+// Check runs in flight against the interpreter being replaced are drained
+// first: ReloadVersion asks the collector runner to quiesce that version's
+// Python workers and holds the barrier until the new interpreter is
+// registered, so no check is ever dispatched against a six handle
+// mid-teardown.
+func ReloadVersion(pythonVersion int, paths ...string) error {
+	release, err := runner.QuiescePython(pythonVersion)
+	if err != nil {
+		return fmt.Errorf("could not quiesce check runner for python reload: %s", err)
 	}
+	defer release()
 
-	if six == nil {
-		return fmt.Errorf("could not init six lib for python version %d", pythonVersion)
+	destroyVersionLocked(pythonVersion)
+
+	paths = append(paths, config.Datadog.GetStringSlice("additional_checksd")...)
+
+	return EnsureRuntime(pythonVersion, paths...)
+}
+
+// DestroyVersion tears down the interpreter for the given major Python
+// version without bringing a new one up. It's a no-op if that runtime
+// isn't running. Use this to retire a runtime left running from before an
+// operator switched `python_version` away from it.
+func DestroyVersion(pythonVersion int) error {
+	release, err := runner.QuiescePython(pythonVersion)
+	if err != nil {
+		return fmt.Errorf("could not quiesce check runner to destroy python runtime %d: %s", pythonVersion, err)
 	}
+	defer release()
 
-	if runtime.GOOS == "windows" {
-		_here, _ := executable.Folder()
-		// on windows, override the hardcoded path set during compile time, but only if that path points to nowhere
-		if _, err := os.Stat(filepath.Join(PythonHome, "lib", "python2.7")); os.IsNotExist(err) {
-			PythonHome = _here
-		}
+	destroyVersionLocked(pythonVersion)
+	return nil
+}
+
+// destroyVersionLocked drops and destroys the interpreter for
+// pythonVersion, if any. The caller must already hold the quiesce barrier
+// for that version.
+func destroyVersionLocked(pythonVersion int) {
+	runtimesMu.Lock()
+	rt, found := runtimes[pythonVersion]
+	if found {
+		delete(runtimes, pythonVersion)
+	}
+	runtimesMu.Unlock()
+
+	if found {
+		// Dropping the six handle also invalidates the gil_state cached
+		// inside it.
+		C.destroy(rt.six)
+	}
+}
+
+// EnsureRuntime initializes the interpreter for the given major Python
+// version if it isn't already running. It is safe to call for a version
+// that's already initialized; the call is then a no-op.
+func EnsureRuntime(pythonVersion int, paths ...string) error {
+	runtimesMu.Lock()
+	defer runtimesMu.Unlock()
+
+	if _, found := runtimes[pythonVersion]; found {
+		return nil
+	}
+
+	rt := &interpreter{pythonHome: platformPythonHome(pythonVersion)}
+
+	switch pythonVersion {
+	case 2:
+		rt.six = C.make2(C.CString(rt.pythonHome))
+	case 3:
+		rt.six = C.make3(C.CString(rt.pythonHome))
+	default:
+		return fmt.Errorf("unknown requested version of python: %d", pythonVersion)
+	}
+
+	if rt.six == nil {
+		return fmt.Errorf("could not init six lib for python version %d", pythonVersion)
 	}
 
 	// Set the PYTHONPATH if needed.
 	for _, p := range paths {
-		C.add_python_path(six, C.CString(p))
+		C.add_python_path(rt.six, C.CString(p))
 	}
 
-	C.init(six)
+	C.init(rt.six)
 
-	if C.is_initialized(six) == 0 {
-		err := C.GoString(C.get_error(six))
+	if C.is_initialized(rt.six) == 0 {
+		err := C.GoString(C.get_error(rt.six))
 		return fmt.Errorf("%s", err)
 	}
 
 	// store the Python version after killing \n chars within the string
-	if res := C.get_py_version(six); res != nil {
-		PythonVersion = strings.Replace(C.GoString(res), "\n", "", -1)
+	if res := C.get_py_version(rt.six); res != nil {
+		rt.version = strings.Replace(C.GoString(res), "\n", "", -1)
 
-		// Set python version in the cache
-		key := cache.BuildAgentKey("pythonVersion")
-		cache.Cache.Set(key, PythonVersion, cache.NoExpiration)
+		// Set python version in the cache, keyed per major version so both
+		// runtimes can be inspected independently.
+		key := cache.BuildAgentKey(fmt.Sprintf("pythonVersion%d", pythonVersion))
+		cache.Cache.Set(key, rt.version, cache.NoExpiration)
 	}
 
 	sendTelemetry(pythonVersion)
 
-	// TODO: query PythonPath
-	// TODO: query PythonHome
+	// Probe the interpreter itself for sys.prefix/sys.path rather than
+	// trusting the compile-time default, which platformPythonHome can only
+	// approximate on some platforms (see init_darwin.go).
+	rt.pythonHome, rt.pythonPath = probePythonEnv(rt.six, rt.pythonHome)
 
-	C.initCgoFree(six)
-	C.initDatadogAgentModule(six)
-	C.initAggregatorModule(six)
-	C.initUtilModule(six)
-	C.initTaggerModule(six)
+	C.initCgoFree(rt.six)
+	C.initDatadogAgentModule(rt.six)
+	C.initAggregatorModule(rt.six)
+	C.initUtilModule(rt.six)
+	C.initTaggerModule(rt.six)
 	initContainerFilter() // special init for the container go code
-	C.initContainersModule(six)
-	C.initkubeutilModule(six)
+	C.initContainersModule(rt.six)
+	C.initkubeutilModule(rt.six)
+
+	runtimes[pythonVersion] = rt
 
 	return nil
 }
 
-// Destroy destroys the loaded Python interpreter initialized by 'Initialize'
+// probePythonEnv queries the freshly initialized interpreter for
+// sys.prefix and sys.path so PythonHome/PythonPath reflect what the
+// runtime actually resolved to, rather than the value it was seeded with.
+// fallbackHome is kept if the interpreter doesn't report a prefix.
+func probePythonEnv(six *C.six_t, fallbackHome string) (home string, path string) {
+	home = fallbackHome
+	if res := C.get_py_home_path(six); res != nil {
+		if h := C.GoString(res); h != "" {
+			home = h
+		}
+	}
+	if res := C.get_py_path(six); res != nil {
+		path = C.GoString(res)
+	}
+	return home, path
+}
+
+// Destroy destroys every loaded Python interpreter initialized by
+// 'Initialize'/'EnsureRuntime'.
 func Destroy() {
-	if six != nil {
-		C.destroy(six)
+	runtimesMu.Lock()
+	defer runtimesMu.Unlock()
+
+	for v, rt := range runtimes {
+		C.destroy(rt.six)
+		delete(runtimes, v)
 	}
 }
 
-// GetSix returns the underlying six_t struct. This is meant for testing and
-// tooling, use the six_t struct at your own risk
+// GetSixFor returns the underlying six_t struct for the given major Python
+// version, or nil if that runtime hasn't been initialized. This lets
+// callers dispatch a check to the interpreter it was scheduled against.
+func GetSixFor(pythonVersion int) *C.six_t {
+	runtimesMu.RLock()
+	defer runtimesMu.RUnlock()
+
+	if rt, found := runtimes[pythonVersion]; found {
+		return rt.six
+	}
+	return nil
+}
+
+// GetSix returns the underlying six_t struct for the interpreter matching
+// the configured `python_version`. This is meant for testing and tooling,
+// use the six_t struct at your own risk.
+//
+// Deprecated: use GetSixFor to target a specific runtime now that Python 2
+// and Python 3 interpreters may be running side by side.
 func GetSix() *C.six_t {
-	return six
+	return GetSixFor(config.Datadog.GetInt("python_version"))
+}
+
+// PythonVersionFor returns the interpreter version string provided by
+// `sys.version` for the given major Python version. It's empty if that
+// runtime was not initialized.
+func PythonVersionFor(pythonVersion int) string {
+	runtimesMu.RLock()
+	defer runtimesMu.RUnlock()
+
+	if rt, found := runtimes[pythonVersion]; found {
+		return rt.version
+	}
+	return ""
+}
+
+// PythonHomeFor returns the computed value of the Python Home path for the
+// given major Python version, once that interpreter has been created. It
+// might be empty in case the runtime wasn't initialized, or the Agent was
+// built using system libs and the env var PYTHONHOME is empty. It's
+// expected to always contain a value when the Agent is built using
+// embedded libs.
+func PythonHomeFor(pythonVersion int) string {
+	runtimesMu.RLock()
+	defer runtimesMu.RUnlock()
+
+	if rt, found := runtimes[pythonVersion]; found {
+		return rt.pythonHome
+	}
+	return ""
+}
+
+// PythonPathFor returns the string representation of the Python list
+// returned by `sys.path` for the given major Python version. It's empty if
+// that runtime was not initialized.
+func PythonPathFor(pythonVersion int) string {
+	runtimesMu.RLock()
+	defer runtimesMu.RUnlock()
+
+	if rt, found := runtimes[pythonVersion]; found {
+		return rt.pythonPath
+	}
+	return ""
+}
+
+// PythonVersion returns PythonVersionFor the interpreter matching the
+// configured `python_version`.
+//
+// Deprecated: use PythonVersionFor to target a specific runtime now that
+// Python 2 and Python 3 interpreters may be running side by side.
+func PythonVersion() string {
+	return PythonVersionFor(config.Datadog.GetInt("python_version"))
+}
+
+// PythonHome returns PythonHomeFor the interpreter matching the configured
+// `python_version`.
+//
+// Deprecated: use PythonHomeFor to target a specific runtime now that
+// Python 2 and Python 3 interpreters may be running side by side.
+func PythonHome() string {
+	return PythonHomeFor(config.Datadog.GetInt("python_version"))
+}
+
+// PythonPath returns PythonPathFor the interpreter matching the configured
+// `python_version`.
+//
+// Deprecated: use PythonPathFor to target a specific runtime now that
+// Python 2 and Python 3 interpreters may be running side by side.
+func PythonPath() string {
+	return PythonPathFor(config.Datadog.GetInt("python_version"))
+}
+
+// Dispatch ensures the interpreter for the given major Python version is
+// running, starting it on demand if needed, and returns the six_t handle
+// the caller should run a check pinned to that version against.
+// PythonCheck.Run uses it to send each Python check instance to the
+// correct interpreter.
+func Dispatch(pythonVersion int, paths ...string) (*C.six_t, error) {
+	if err := EnsureRuntime(pythonVersion, paths...); err != nil {
+		return nil, err
+	}
+	return GetSixFor(pythonVersion), nil
+}
+
+// VersionForSix returns the major Python version whose interpreter owns
+// the given six_t handle, or 0 if the handle isn't currently registered.
+// C callbacks (SubmitMetric, GetSubprocessOutput, Tags, ...) now receive
+// the six_t* that invoked them as their first argument; this lets their Go
+// implementations look up which runtime dispatched the call.
+func VersionForSix(six *C.six_t) int {
+	runtimesMu.RLock()
+	defer runtimesMu.RUnlock()
+
+	for v, rt := range runtimes {
+		if rt.six == six {
+			return v
+		}
+	}
+	return 0
 }
@@ -0,0 +1,34 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build python
+// +build darwin
+
+package python
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/DataDog/datadog-agent/pkg/util/executable"
+)
+
+/*
+#cgo LDFLAGS: -ldatadog-agent-six
+*/
+import "C"
+
+// platformPythonHome computes PythonHome from the running agent binary's
+// location instead of trusting the value baked in via -ldflags: macOS
+// embedded builds lay Python out relative to the app bundle, and that
+// layout (and the bundle's install location) isn't known at link time, so
+// pythonHome2/pythonHome3 are frequently stale by the time the agent runs.
+func platformPythonHome(pythonVersion int) string {
+	here, err := executable.Folder()
+	if err != nil {
+		return pythonHomeFor(pythonVersion)
+	}
+	return filepath.Join(here, "..", "Frameworks", fmt.Sprintf("python%d", pythonVersion))
+}
@@ -0,0 +1,22 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build python
+// +build freebsd midnightbsd
+
+package python
+
+/*
+#cgo LDFLAGS: -ldatadog-agent-six
+*/
+import "C"
+
+// platformPythonHome returns the PythonHome baked in at compile time via
+// -ldflags. Unlike on Linux, libdatadog-agent-six on BSD links libdl
+// statically (there's no standalone libdl to link against), so we must not
+// add -ldl ourselves here.
+func platformPythonHome(pythonVersion int) string {
+	return pythonHomeFor(pythonVersion)
+}
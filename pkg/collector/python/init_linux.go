@@ -0,0 +1,21 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build python
+// +build linux
+
+package python
+
+/*
+#cgo LDFLAGS: -ldatadog-agent-six -ldl
+*/
+import "C"
+
+// platformPythonHome returns the PythonHome baked in at compile time via
+// -ldflags. Embedded Linux builds ship their own copy of the interpreter
+// there, so the compile-time default is trustworthy.
+func platformPythonHome(pythonVersion int) string {
+	return pythonHomeFor(pythonVersion)
+}
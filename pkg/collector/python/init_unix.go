@@ -0,0 +1,23 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build python
+// +build !windows,!darwin,!freebsd,!midnightbsd,!linux
+
+package python
+
+/*
+#cgo LDFLAGS: -ldatadog-agent-six -ldl
+*/
+import "C"
+
+// platformPythonHome returns the PythonHome baked in at compile time via
+// -ldflags. This is the fallback for unix-like targets that don't need
+// their own init_<goos>.go (e.g. Solaris, AIX): it reproduces the
+// `#cgo !windows LDFLAGS: -ldl` behavior this package used to have for
+// every non-Windows GOOS before Darwin/BSD got dedicated handling.
+func platformPythonHome(pythonVersion int) string {
+	return pythonHomeFor(pythonVersion)
+}
@@ -0,0 +1,44 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build python
+// +build windows
+
+package python
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/DataDog/datadog-agent/pkg/util/executable"
+)
+
+/*
+#cgo windows LDFLAGS: -ldatadog-agent-six -lstdc++ -static
+*/
+import "C"
+
+// windowsPythonLib is the lib directory name the embedded Windows build
+// lays a given major Python version's standard library out under.
+func windowsPythonLib(pythonVersion int) string {
+	if pythonVersion == 2 {
+		return "python2.7"
+	}
+	return fmt.Sprintf("python%d", pythonVersion)
+}
+
+// platformPythonHome returns the PythonHome baked in at compile time via
+// -ldflags, unless that path points nowhere for the requested major
+// version, in which case it falls back to the directory the agent binary
+// was launched from.
+func platformPythonHome(pythonVersion int) string {
+	home := pythonHomeFor(pythonVersion)
+	here, _ := executable.Folder()
+	if _, err := os.Stat(filepath.Join(home, "lib", windowsPythonLib(pythonVersion))); os.IsNotExist(err) {
+		home = here
+	}
+	return home
+}
@@ -0,0 +1,102 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package runner
+
+import (
+	"fmt"
+	"sync"
+)
+
+// pythonGate coordinates in-flight Python check runs pinned to one major
+// Python version against a reload of the interpreter they run against:
+// runs register themselves while active, and a quiesce barrier blocks new
+// registrations and waits for the active ones to drain before handing
+// control back to the caller.
+type pythonGate struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	active int
+	closed bool
+}
+
+func newPythonGate() *pythonGate {
+	g := &pythonGate{}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+var (
+	pythonGatesMu sync.Mutex
+	// pythonGates is keyed by major Python version (2 or 3).
+	pythonGates = map[int]*pythonGate{}
+)
+
+func pythonGateFor(pythonVersion int) *pythonGate {
+	pythonGatesMu.Lock()
+	defer pythonGatesMu.Unlock()
+
+	g, found := pythonGates[pythonVersion]
+	if !found {
+		g = newPythonGate()
+		pythonGates[pythonVersion] = g
+	}
+	return g
+}
+
+// EnterPythonCheck registers an in-flight run of a check pinned to the
+// given major Python version and returns a func that must be called when
+// the run completes. It blocks while a QuiescePython barrier is held for
+// that version, so a check run never starts against an interpreter that's
+// mid-reload. python.PythonCheck.Run brackets every check run with this
+// call, which is what makes the QuiescePython guarantee below true rather
+// than a barrier with nothing on the other side of the gate.
+func EnterPythonCheck(pythonVersion int) func() {
+	g := pythonGateFor(pythonVersion)
+
+	g.mu.Lock()
+	for g.closed {
+		g.cond.Wait()
+	}
+	g.active++
+	g.mu.Unlock()
+
+	return func() {
+		g.mu.Lock()
+		g.active--
+		if g.active == 0 {
+			g.cond.Broadcast()
+		}
+		g.mu.Unlock()
+	}
+}
+
+// QuiescePython blocks new check runs pinned to pythonVersion from
+// starting, waits for any already in flight to finish, and returns a
+// release func that must be called to let the runner resume dispatching
+// checks to that interpreter. It's the barrier python.Reload/ReloadVersion
+// hold while tearing down and recreating a six handle, so no check is ever
+// dispatched against an interpreter mid-teardown.
+func QuiescePython(pythonVersion int) (func(), error) {
+	g := pythonGateFor(pythonVersion)
+
+	g.mu.Lock()
+	if g.closed {
+		g.mu.Unlock()
+		return nil, fmt.Errorf("python runtime %d is already being reloaded", pythonVersion)
+	}
+	g.closed = true
+	for g.active > 0 {
+		g.cond.Wait()
+	}
+	g.mu.Unlock()
+
+	return func() {
+		g.mu.Lock()
+		g.closed = false
+		g.cond.Broadcast()
+		g.mu.Unlock()
+	}, nil
+}